@@ -0,0 +1,102 @@
+package palettize
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// BrightnessFunc computes a sortable brightness value for a color. Lower
+// values are considered darker.
+type BrightnessFunc func(color.Color) float64
+
+// sumBrightness sums the raw RGB channels. This is palettize's original
+// brightness metric: cheap, but it treats pure red, green, and blue as
+// equally bright, which is a poor match for human perception.
+func sumBrightness(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return float64(r + g + b)
+}
+
+// luminanceBrightness computes WCAG 2.1 relative luminance, which weights
+// channels by how bright humans actually perceive them.
+func luminanceBrightness(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return 0.2126*linearize(r) + 0.7152*linearize(g) + 0.0722*linearize(b)
+}
+
+// linearize converts a 16-bit sRGB channel value (as returned by
+// color.Color.RGBA) to linear light.
+func linearize(c uint32) float64 {
+	cs := float64(c) / 65535
+	if cs <= 0.03928 {
+		return cs / 12.92
+	}
+	return math.Pow((cs+0.055)/1.055, 2.4)
+}
+
+// hsvValueBrightness uses the "value" component of HSV: the largest channel.
+func hsvValueBrightness(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return float64(max3(r, g, b))
+}
+
+// hslLightnessBrightness uses the "lightness" component of HSL: the average
+// of the largest and smallest channels.
+func hslLightnessBrightness(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return float64(max3(r, g, b)+min3(r, g, b)) / 2
+}
+
+func max3(a, b, c uint32) uint32 {
+	m := a
+	if b > m {
+		m = b
+	}
+	if c > m {
+		m = c
+	}
+	return m
+}
+
+func min3(a, b, c uint32) uint32 {
+	m := a
+	if b < m {
+		m = b
+	}
+	if c < m {
+		m = c
+	}
+	return m
+}
+
+// brightnessFuncs maps --brightness flag values to their implementations.
+var brightnessFuncs = map[string]BrightnessFunc{
+	"sum":           sumBrightness,
+	"luminance":     luminanceBrightness,
+	"hsv-value":     hsvValueBrightness,
+	"hsl-lightness": hslLightnessBrightness,
+}
+
+// BrightnessFuncByName looks up a BrightnessFunc by the name given to
+// --brightness.
+func BrightnessFuncByName(name string) (BrightnessFunc, error) {
+	fn, ok := brightnessFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown brightness metric: %s", name)
+	}
+	return fn, nil
+}
+
+// byBrightness implements sort.Interface for []color.Color, ordering colors
+// from least to most bright according to fn.
+type byBrightness struct {
+	colors []color.Color
+	fn     BrightnessFunc
+}
+
+func (a byBrightness) Len() int      { return len(a.colors) }
+func (a byBrightness) Swap(i, j int) { a.colors[i], a.colors[j] = a.colors[j], a.colors[i] }
+func (a byBrightness) Less(i, j int) bool {
+	return a.fn(a.colors[i]) < a.fn(a.colors[j])
+}
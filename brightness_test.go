@@ -0,0 +1,71 @@
+package palettize
+
+import (
+	"image/color"
+	"sort"
+	"testing"
+)
+
+func TestBrightnessFuncByName(t *testing.T) {
+	for name := range brightnessFuncs {
+		if _, err := BrightnessFuncByName(name); err != nil {
+			t.Errorf("BrightnessFuncByName(%q) returned error: %v", name, err)
+		}
+	}
+	if _, err := BrightnessFuncByName("bogus"); err == nil {
+		t.Error("BrightnessFuncByName(\"bogus\") returned nil error, want one")
+	}
+}
+
+func TestSumBrightness(t *testing.T) {
+	if got, want := sumBrightness(color.Black), 0.0; got != want {
+		t.Errorf("sumBrightness(black) = %v, want %v", got, want)
+	}
+	white := sumBrightness(color.White)
+	red := sumBrightness(color.RGBA{R: 255, A: 255})
+	if white <= red {
+		t.Errorf("sumBrightness(white) = %v, want greater than sumBrightness(red) = %v", white, red)
+	}
+}
+
+func TestLuminanceBrightness(t *testing.T) {
+	if got, want := luminanceBrightness(color.Black), 0.0; got != want {
+		t.Errorf("luminanceBrightness(black) = %v, want %v", got, want)
+	}
+	if got, want := luminanceBrightness(color.White), 1.0; !approxEqual(got, want, 1e-9) {
+		t.Errorf("luminanceBrightness(white) = %v, want %v", got, want)
+	}
+	// Pure green is weighted far brighter than pure blue under WCAG
+	// luminance, unlike sumBrightness which treats them identically.
+	green := luminanceBrightness(color.RGBA{G: 255, A: 255})
+	blue := luminanceBrightness(color.RGBA{B: 255, A: 255})
+	if green <= blue {
+		t.Errorf("luminanceBrightness(green) = %v, want greater than luminanceBrightness(blue) = %v", green, blue)
+	}
+}
+
+func TestHSVValueBrightness(t *testing.T) {
+	c := color.RGBA{R: 10, G: 200, B: 50, A: 255}
+	want := float64(200 * 0x101)
+	if got := hsvValueBrightness(c); got != want {
+		t.Errorf("hsvValueBrightness(%v) = %v, want %v", c, got, want)
+	}
+}
+
+func TestHSLLightnessBrightness(t *testing.T) {
+	c := color.RGBA{R: 10, G: 200, B: 50, A: 255}
+	want := float64(200*0x101+10*0x101) / 2
+	if got := hslLightnessBrightness(c); got != want {
+		t.Errorf("hslLightnessBrightness(%v) = %v, want %v", c, got, want)
+	}
+}
+
+func TestByBrightnessSortsAscending(t *testing.T) {
+	colors := []color.Color{color.White, color.Black, color.RGBA{R: 128, G: 128, B: 128, A: 255}}
+	sort.Sort(byBrightness{colors, sumBrightness})
+	for i := 1; i < len(colors); i++ {
+		if sumBrightness(colors[i-1]) > sumBrightness(colors[i]) {
+			t.Errorf("colors not sorted ascending by brightness: %v", colors)
+		}
+	}
+}
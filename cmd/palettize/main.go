@@ -0,0 +1,135 @@
+/*
+Command palettize creates a composite image using the brightness of one
+image and the color palette of another. Supports GIF (including animated
+GIF), JPEG, and PNG files.
+
+Example syntax:
+
+	palettize original.png palette.png result.png
+
+The -brightness flag selects the metric used to sort and compare colors: sum
+(the default, and the historical behavior), luminance (WCAG 2.1 relative
+luminance), hsv-value, or hsl-lightness.
+
+The -colors flag quantizes each input image to N representative colors via
+k-means instead of using every unique color, which keeps large photographic
+palettes (and the mapping between them) small and well-behaved.
+
+The -dither flag reduces banding when mapping onto small palettes: none (the
+default), floyd-steinberg, bayer4, or bayer8. -dither-spread controls the
+strength of bayer4/bayer8 dithering; 0 (the default) picks 1/len(new
+palette).
+
+The -space flag selects the color space used for sorting and nearest-color
+mapping: rgb (the default), lab, or oklab. Setting it to lab or oklab sorts
+by that space's lightness instead of -brightness, since RGB distance is a
+poor proxy for perceptual difference.
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/gif"
+	"os"
+
+	"github.com/jangler/palettize"
+)
+
+var brightnessFlag = flag.String("brightness", "sum",
+	"brightness metric used to sort palettes: sum, luminance, hsv-value, or hsl-lightness")
+var colorsFlag = flag.Int("colors", 0,
+	"quantize each input to this many representative colors via k-means (0 disables quantization)")
+var ditherFlag = flag.String("dither", "none",
+	"dithering applied before palette lookup: none, floyd-steinberg, bayer4, or bayer8")
+var ditherSpreadFlag = flag.Float64("dither-spread", 0,
+	"threshold spread for bayer dithering (0 picks 1/len(new palette))")
+var spaceFlag = flag.String("space", "rgb",
+	"color space used for sorting and nearest-color mapping: rgb, lab, or oklab")
+
+// Prints an error message to stderr and exits with a non-zero status.
+func die(err error) {
+	fmt.Fprintf(os.Stderr, err.Error()+"\n")
+	os.Exit(1)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 3 {
+		die(errors.New(fmt.Sprintf("Usage: %s [flags] original palette result",
+			os.Args[0])))
+	}
+
+	brightness, err := palettize.BrightnessFuncByName(*brightnessFlag)
+	if err != nil {
+		die(err)
+	}
+	dither, err := palettize.DitherModeByName(*ditherFlag)
+	if err != nil {
+		die(err)
+	}
+	space, err := palettize.ColorSpaceByName(*spaceFlag)
+	if err != nil {
+		die(err)
+	}
+
+	opts := &palettize.Options{
+		Colors:       *colorsFlag,
+		Brightness:   brightness,
+		Space:        space,
+		Dither:       dither,
+		DitherSpread: *ditherSpreadFlag,
+	}
+
+	target, err := palettize.ReadImage(flag.Arg(1))
+	if err != nil {
+		die(err)
+	}
+
+	if g, err := readGIF(flag.Arg(0)); err == nil && len(g.Image) > 1 {
+		if err := palettize.PalettizeGIF(g, target, opts); err != nil {
+			die(err)
+		}
+		if err := writeGIF(g, flag.Arg(2)); err != nil {
+			die(err)
+		}
+		return
+	}
+
+	src, err := palettize.ReadImage(flag.Arg(0))
+	if err != nil {
+		die(err)
+	}
+
+	dst := image.NewRGBA(src.Bounds())
+	if err := palettize.Palettize(dst, src, target, opts); err != nil {
+		die(err)
+	}
+
+	if err := palettize.WriteImage(dst, flag.Arg(2)); err != nil {
+		die(err)
+	}
+}
+
+// readGIF decodes every frame of a GIF file, returning an error for any
+// other file type. Callers distinguish animated GIFs by frame count and
+// fall back to the still-image path otherwise.
+func readGIF(filename string) (*gif.GIF, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return gif.DecodeAll(file)
+}
+
+func writeGIF(g *gif.GIF, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return gif.EncodeAll(file, g)
+}
@@ -0,0 +1,165 @@
+package palettize
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// ColorSpace selects how colors are compared for sorting and nearest-color
+// mapping. RGB distance doesn't match human perception well; Lab and Oklab
+// are both designed so that Euclidean distance approximates perceptual
+// difference.
+type ColorSpace int
+
+const (
+	SpaceRGB ColorSpace = iota
+	SpaceLab
+	SpaceOklab
+)
+
+// ColorSpaceByName looks up a ColorSpace by the name given to --space.
+func ColorSpaceByName(name string) (ColorSpace, error) {
+	switch name {
+	case "rgb":
+		return SpaceRGB, nil
+	case "lab":
+		return SpaceLab, nil
+	case "oklab":
+		return SpaceOklab, nil
+	}
+	return 0, fmt.Errorf("unknown color space: %s", name)
+}
+
+// point converts a color to a 3-D point in this space, for use by the k-d
+// tree's nearest-color search.
+func (s ColorSpace) point(c color.Color) [3]float64 {
+	switch s {
+	case SpaceLab:
+		l, a, b := toLab(c)
+		return [3]float64{l, a, b}
+	case SpaceOklab:
+		l, a, b := toOklab(c)
+		return [3]float64{l, a, b}
+	default:
+		return rgbPoint(c)
+	}
+}
+
+// brightness returns a BrightnessFunc that sorts by this space's lightness
+// component, or nil for SpaceRGB, where the caller's chosen --brightness
+// metric should apply instead.
+func (s ColorSpace) brightness() BrightnessFunc {
+	switch s {
+	case SpaceLab:
+		return func(c color.Color) float64 { l, _, _ := toLab(c); return l }
+	case SpaceOklab:
+		return func(c color.Color) float64 { l, _, _ := toOklab(c); return l }
+	default:
+		return nil
+	}
+}
+
+// D65 white point, used by toLab.
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+// toXYZ converts a color to CIE XYZ under the D65 illuminant.
+func toXYZ(c color.Color) (x, y, z float64) {
+	r, g, b, _ := c.RGBA()
+	lr, lg, lb := linearize(r), linearize(g), linearize(b)
+	x = 0.4124*lr + 0.3576*lg + 0.1805*lb
+	y = 0.2126*lr + 0.7152*lg + 0.0722*lb
+	z = 0.0193*lr + 0.1192*lg + 0.9505*lb
+	return
+}
+
+// labF is the nonlinear function used by the XYZ-to-Lab conversion.
+func labF(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29.0
+}
+
+// toLab converts a color to CIE L*a*b*, D65-adapted.
+func toLab(c color.Color) (l, a, b float64) {
+	x, y, z := toXYZ(c)
+	fx := labF(x / whiteX)
+	fy := labF(y / whiteY)
+	fz := labF(z / whiteZ)
+	l = 116*fy - 16
+	a = 500 * (fx - fy)
+	b = 200 * (fy - fz)
+	return
+}
+
+// toOklab converts a color to Oklab (Björn Ottosson's perceptual space).
+func toOklab(c color.Color) (l, a, b float64) {
+	r, g, bl, _ := c.RGBA()
+	lr, lg, lb := linearize(r), linearize(g), linearize(bl)
+
+	ll := 0.4122214708*lr + 0.5363325363*lg + 0.0514459929*lb
+	mm := 0.2119034982*lr + 0.6806995451*lg + 0.1073969566*lb
+	ss := 0.0883024619*lr + 0.2817188376*lg + 0.6299787005*lb
+
+	ll, mm, ss = math.Cbrt(ll), math.Cbrt(mm), math.Cbrt(ss)
+
+	l = 0.2104542553*ll + 0.7936177850*mm - 0.0040720468*ss
+	a = 1.9779984951*ll - 2.4285922050*mm + 0.4505937099*ss
+	b = 0.0259040371*ll + 0.7827717662*mm - 0.8086757660*ss
+	return
+}
+
+// xyzToLinearRGB is the inverse of toXYZ's matrix, converting CIE XYZ under
+// the D65 illuminant back to linear sRGB.
+func xyzToLinearRGB(x, y, z float64) (r, g, b float64) {
+	r = 3.2406*x - 1.5372*y - 0.4986*z
+	g = -0.9689*x + 1.8758*y + 0.0415*z
+	b = 0.0557*x - 0.2040*y + 1.0570*z
+	return
+}
+
+// labFInv is the inverse of labF.
+func labFInv(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta {
+		return t * t * t
+	}
+	return 3 * delta * delta * (t - 4.0/29.0)
+}
+
+// labToColor converts CIE L*a*b*, D65-adapted, back to an opaque sRGB color.
+// It's the inverse of toLab, used to turn k-means centroids computed in Lab
+// space back into displayable colors.
+func labToColor(l, a, b float64) color.Color {
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+	x := labFInv(fx) * whiteX
+	y := labFInv(fy) * whiteY
+	z := labFInv(fz) * whiteZ
+	lr, lg, lb := xyzToLinearRGB(x, y, z)
+	return color.RGBA64{R: delinearize(lr), G: delinearize(lg), B: delinearize(lb), A: 0xffff}
+}
+
+// oklabToColor converts Oklab back to an opaque sRGB color. It's the
+// inverse of toOklab, used to turn k-means centroids computed in Oklab
+// space back into displayable colors.
+func oklabToColor(l, a, b float64) color.Color {
+	ll := l + 0.3963377774*a + 0.2158037573*b
+	mm := l - 0.1055613458*a - 0.0638541728*b
+	ss := l - 0.0894841775*a - 1.2914855480*b
+
+	ll, mm, ss = ll*ll*ll, mm*mm*mm, ss*ss*ss
+
+	lr := 4.0767416621*ll - 3.3077115913*mm + 0.2309699292*ss
+	lg := -1.2684380046*ll + 2.6097574011*mm - 0.3413193965*ss
+	lb := -0.0041960863*ll - 0.7034186147*mm + 1.7076147010*ss
+
+	return color.RGBA64{R: delinearize(lr), G: delinearize(lg), B: delinearize(lb), A: 0xffff}
+}
@@ -0,0 +1,104 @@
+package palettize
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func approxEqual(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestToLabKnownValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		c         color.Color
+		l, a, b   float64
+		tolerance float64
+	}{
+		{"white", color.White, 100, 0, 0, 0.1},
+		{"black", color.Black, 0, 0, 0, 0.1},
+		{"red", color.RGBA{R: 255, A: 255}, 53.24, 80.09, 67.20, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, a, b := toLab(tt.c)
+			if !approxEqual(l, tt.l, tt.tolerance) || !approxEqual(a, tt.a, tt.tolerance) || !approxEqual(b, tt.b, tt.tolerance) {
+				t.Errorf("toLab(%v) = (%.2f, %.2f, %.2f), want (%.2f, %.2f, %.2f)",
+					tt.c, l, a, b, tt.l, tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestToOklabKnownValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		c         color.Color
+		l, a, b   float64
+		tolerance float64
+	}{
+		{"white", color.White, 1.0, 0, 0, 0.01},
+		{"black", color.Black, 0, 0, 0, 0.01},
+		{"red", color.RGBA{R: 255, A: 255}, 0.6280, 0.2249, 0.1259, 0.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, a, b := toOklab(tt.c)
+			if !approxEqual(l, tt.l, tt.tolerance) || !approxEqual(a, tt.a, tt.tolerance) || !approxEqual(b, tt.b, tt.tolerance) {
+				t.Errorf("toOklab(%v) = (%.4f, %.4f, %.4f), want (%.4f, %.4f, %.4f)",
+					tt.c, l, a, b, tt.l, tt.a, tt.b)
+			}
+		})
+	}
+}
+
+// TestLabRoundTrip checks that labToColor inverts toLab closely enough to
+// round-trip an 8-bit sRGB color, since quantizePalette relies on this to
+// turn Lab centroids back into colors.
+func TestLabRoundTrip(t *testing.T) {
+	colors := []color.Color{
+		color.White, color.Black,
+		color.RGBA{R: 255, A: 255},
+		color.RGBA{G: 255, A: 255},
+		color.RGBA{B: 255, A: 255},
+		color.RGBA{R: 128, G: 64, B: 200, A: 255},
+	}
+	for _, c := range colors {
+		l, a, b := toLab(c)
+		got := labToColor(l, a, b)
+		wantR, wantG, wantB, _ := c.RGBA()
+		gotR, gotG, gotB, _ := got.RGBA()
+		if absDiffUint32(gotR, wantR) > 300 || absDiffUint32(gotG, wantG) > 300 || absDiffUint32(gotB, wantB) > 300 {
+			t.Errorf("labToColor(toLab(%v)) = %v, want close to %v", c, got, c)
+		}
+	}
+}
+
+// TestOklabRoundTrip is the Oklab analog of TestLabRoundTrip.
+func TestOklabRoundTrip(t *testing.T) {
+	colors := []color.Color{
+		color.White, color.Black,
+		color.RGBA{R: 255, A: 255},
+		color.RGBA{G: 255, A: 255},
+		color.RGBA{B: 255, A: 255},
+		color.RGBA{R: 128, G: 64, B: 200, A: 255},
+	}
+	for _, c := range colors {
+		l, a, b := toOklab(c)
+		got := oklabToColor(l, a, b)
+		wantR, wantG, wantB, _ := c.RGBA()
+		gotR, gotG, gotB, _ := got.RGBA()
+		if absDiffUint32(gotR, wantR) > 300 || absDiffUint32(gotG, wantG) > 300 || absDiffUint32(gotB, wantB) > 300 {
+			t.Errorf("oklabToColor(toOklab(%v)) = %v, want close to %v", c, got, c)
+		}
+	}
+}
+
+func absDiffUint32(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,152 @@
+package palettize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// DitherMode identifies the dithering algorithm applied to a pixel before
+// palette lookup.
+type DitherMode int
+
+const (
+	DitherNone DitherMode = iota
+	DitherFloydSteinberg
+	DitherBayer4
+	DitherBayer8
+)
+
+// DitherModeByName looks up a DitherMode by the name given to --dither.
+func DitherModeByName(name string) (DitherMode, error) {
+	switch name {
+	case "none":
+		return DitherNone, nil
+	case "floyd-steinberg":
+		return DitherFloydSteinberg, nil
+	case "bayer4":
+		return DitherBayer4, nil
+	case "bayer8":
+		return DitherBayer8, nil
+	}
+	return 0, fmt.Errorf("unknown dither mode: %s", name)
+}
+
+// bayer4 and bayer8 are the standard Bayer ordered-dither matrices.
+var bayer4 = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+var bayer8 = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// clampChannel clamps a dithered channel value to the legal 16-bit range.
+func clampChannel(v float64) uint16 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 65535:
+		return 65535
+	default:
+		return uint16(v)
+	}
+}
+
+// orderedDither adds a Bayer threshold, scaled by spread, to every channel
+// of c before nearest-palette lookup. mode must be DitherBayer4 or
+// DitherBayer8.
+func orderedDither(c color.Color, x, y int, mode DitherMode, spread float64) color.Color {
+	var n int
+	var m int
+	switch mode {
+	case DitherBayer4:
+		n = 4
+		m = bayer4[y%n][x%n]
+	case DitherBayer8:
+		n = 8
+		m = bayer8[y%n][x%n]
+	}
+
+	threshold := (float64(m)/float64(n*n) - 0.5) * spread
+	r, g, b, a := c.RGBA()
+	return color.RGBA64{
+		R: clampChannel(float64(r) + threshold),
+		G: clampChannel(float64(g) + threshold),
+		B: clampChannel(float64(b) + threshold),
+		A: uint16(a),
+	}
+}
+
+// floydSteinberg implements Floyd-Steinberg error diffusion over an image
+// traversed column-major (x outer, y inner), matching palettize's main
+// loop: error must only ever flow to pixels that haven't been visited yet,
+// which here means later y within the same column, and any y in later
+// columns.
+type floydSteinberg struct {
+	bounds           image.Rectangle
+	errR, errG, errB []float64
+}
+
+func newFloydSteinberg(b image.Rectangle) *floydSteinberg {
+	n := b.Dx() * b.Dy()
+	return &floydSteinberg{
+		bounds: b,
+		errR:   make([]float64, n),
+		errG:   make([]float64, n),
+		errB:   make([]float64, n),
+	}
+}
+
+func (f *floydSteinberg) offset(x, y int) int {
+	return (x-f.bounds.Min.X)*f.bounds.Dy() + (y - f.bounds.Min.Y)
+}
+
+// Apply returns c with any error accumulated for (x, y) mixed in, clamped
+// to a legal 16-bit range.
+func (f *floydSteinberg) Apply(c color.Color, x, y int) color.Color {
+	i := f.offset(x, y)
+	r, g, b, a := c.RGBA()
+	return color.RGBA64{
+		R: clampChannel(float64(r) + f.errR[i]),
+		G: clampChannel(float64(g) + f.errG[i]),
+		B: clampChannel(float64(b) + f.errB[i]),
+		A: uint16(a),
+	}
+}
+
+// Propagate distributes the quantization error between adjusted (the color
+// Apply produced) and chosen (the palette color it was mapped to) across
+// the not-yet-visited neighbors of (x, y).
+func (f *floydSteinberg) Propagate(adjusted, chosen color.Color, x, y int) {
+	ar, ag, ab, _ := adjusted.RGBA()
+	cr, cg, cb, _ := chosen.RGBA()
+	er := float64(ar) - float64(cr)
+	eg := float64(ag) - float64(cg)
+	eb := float64(ab) - float64(cb)
+
+	f.add(x, y+1, er*7/16, eg*7/16, eb*7/16)
+	f.add(x+1, y-1, er*3/16, eg*3/16, eb*3/16)
+	f.add(x+1, y, er*5/16, eg*5/16, eb*5/16)
+	f.add(x+1, y+1, er*1/16, eg*1/16, eb*1/16)
+}
+
+func (f *floydSteinberg) add(x, y int, dr, dg, db float64) {
+	if !(image.Point{x, y}.In(f.bounds)) {
+		return
+	}
+	i := f.offset(x, y)
+	f.errR[i] += dr
+	f.errG[i] += dg
+	f.errB[i] += db
+}
@@ -0,0 +1,90 @@
+package palettize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDitherModeByName(t *testing.T) {
+	tests := map[string]DitherMode{
+		"none":            DitherNone,
+		"floyd-steinberg": DitherFloydSteinberg,
+		"bayer4":          DitherBayer4,
+		"bayer8":          DitherBayer8,
+	}
+	for name, want := range tests {
+		got, err := DitherModeByName(name)
+		if err != nil {
+			t.Errorf("DitherModeByName(%q) returned error: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("DitherModeByName(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if _, err := DitherModeByName("bogus"); err == nil {
+		t.Error("DitherModeByName(\"bogus\") returned nil error, want one")
+	}
+}
+
+func TestOrderedDitherAddsThreshold(t *testing.T) {
+	c := color.RGBA{R: 128, G: 128, B: 128, A: 255}
+	got := orderedDither(c, 0, 0, DitherBayer4, 1.0)
+	r, g, b, a := got.RGBA()
+	wantR, wantG, wantB, wantA := c.RGBA()
+	if r == wantR && g == wantG && b == wantB {
+		t.Errorf("orderedDither(%v) = %v, channels unchanged from input", c, got)
+	}
+	if a != wantA {
+		t.Errorf("orderedDither(%v) changed alpha: got %v, want %v", c, a, wantA)
+	}
+}
+
+func TestOrderedDitherClampsOutOfRange(t *testing.T) {
+	// bayer4[0][0] is the matrix's minimum entry, so a large spread pushes
+	// the threshold as negative as it gets; a pixel near black should clamp
+	// to 0 rather than wrapping or going negative.
+	c := color.RGBA{R: 1, G: 1, B: 1, A: 255}
+	got := orderedDither(c, 0, 0, DitherBayer4, 1e6)
+	r, g, b, _ := got.RGBA()
+	if r != 0 || g != 0 || b != 0 {
+		t.Errorf("orderedDither with extreme negative threshold = %v, want all channels clamped to 0", got)
+	}
+}
+
+func TestFloydSteinbergPropagateDistributesError(t *testing.T) {
+	b := image.Rect(0, 0, 3, 3)
+	fs := newFloydSteinberg(b)
+
+	adjusted := color.RGBA64{R: 40000, A: 0xffff}
+	chosen := color.RGBA64{R: 0, A: 0xffff}
+	fs.Propagate(adjusted, chosen, 1, 1)
+
+	// All four of Floyd-Steinberg's down/forward neighbors should have
+	// accumulated some of the red error; (1,1) itself and its
+	// already-visited neighbors should not.
+	neighbors := [][2]int{{1, 2}, {2, 0}, {2, 1}, {2, 2}}
+	for _, n := range neighbors {
+		if fs.errR[fs.offset(n[0], n[1])] == 0 {
+			t.Errorf("no error propagated to neighbor (%d, %d)", n[0], n[1])
+		}
+	}
+	if fs.errR[fs.offset(1, 1)] != 0 {
+		t.Error("Propagate modified the source pixel's own error")
+	}
+
+	applied := fs.Apply(color.RGBA64{A: 0xffff}, 2, 1)
+	r, _, _, _ := applied.RGBA()
+	if r == 0 {
+		t.Error("Apply at a neighbor didn't pick up propagated error")
+	}
+}
+
+func TestFloydSteinbergAddIgnoresOutOfBounds(t *testing.T) {
+	b := image.Rect(0, 0, 2, 2)
+	fs := newFloydSteinberg(b)
+	// Propagating from a corner pushes error toward neighbors outside
+	// bounds; add must silently drop those rather than panicking on an
+	// out-of-range offset.
+	fs.Propagate(color.RGBA64{R: 40000, A: 0xffff}, color.RGBA64{A: 0xffff}, 1, 1)
+}
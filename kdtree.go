@@ -0,0 +1,106 @@
+package palettize
+
+import (
+	"image/color"
+	"sort"
+)
+
+// kdNode is one node of a 3-D k-d tree, splitting on the tree's three axes
+// in turn as depth increases.
+type kdNode struct {
+	point       [3]float64
+	index       int
+	left, right *kdNode
+}
+
+// KDPalette is a palette of colors backed by a k-d tree, supporting
+// nearest-color lookup in O(log N) expected time instead of the O(N) linear
+// scan indexOf requires. point converts a color to the 3-D space the tree
+// searches in, which need not be RGB.
+type KDPalette struct {
+	root  *kdNode
+	point func(color.Color) [3]float64
+}
+
+// NewKDPalette builds a k-d tree over colors using point to place each
+// color in 3-D space. NearestIndex on the result returns indices into this
+// same colors slice.
+func NewKDPalette(colors []color.Color, point func(color.Color) [3]float64) *KDPalette {
+	nodes := make([]*kdNode, len(colors))
+	for i, c := range colors {
+		nodes[i] = &kdNode{point: point(c), index: i}
+	}
+	return &KDPalette{root: buildKDTree(nodes, 0), point: point}
+}
+
+// rgbPoint converts a color to a point in 3-D RGB space.
+func rgbPoint(c color.Color) [3]float64 {
+	r, g, b, _ := c.RGBA()
+	return [3]float64{float64(r), float64(g), float64(b)}
+}
+
+// buildKDTree recursively partitions nodes on the median of the current
+// axis, cycling through R, G, and B as depth increases. It reorders nodes
+// in place.
+func buildKDTree(nodes []*kdNode, depth int) *kdNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	axis := depth % 3
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].point[axis] < nodes[j].point[axis]
+	})
+
+	mid := len(nodes) / 2
+	node := nodes[mid]
+	node.left = buildKDTree(nodes[:mid], depth+1)
+	node.right = buildKDTree(nodes[mid+1:], depth+1)
+	return node
+}
+
+// NearestIndex returns the index of the color in the original palette that
+// is closest to c, in the space the tree was built with.
+func (t *KDPalette) NearestIndex(c color.Color) int {
+	if t.root == nil {
+		return -1
+	}
+
+	target := t.point(c)
+	best := t.root
+	bestDist := sqDist3(target, best.point)
+	searchKDTree(t.root, target, 0, &best, &bestDist)
+	return best.index
+}
+
+func sqDist3(a, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
+
+// searchKDTree descends the tree rooted at node looking for the point
+// nearest to target, updating best and bestDist, and only recursing into a
+// subtree when its splitting plane could contain something closer than the
+// current best.
+func searchKDTree(node *kdNode, target [3]float64, depth int, best **kdNode, bestDist *float64) {
+	if node == nil {
+		return
+	}
+
+	if d := sqDist3(target, node.point); d < *bestDist {
+		*best = node
+		*bestDist = d
+	}
+
+	axis := depth % 3
+	diff := target[axis] - node.point[axis]
+	near, far := node.left, node.right
+	if diff > 0 {
+		near, far = far, near
+	}
+
+	searchKDTree(near, target, depth+1, best, bestDist)
+	if diff*diff < *bestDist {
+		searchKDTree(far, target, depth+1, best, bestDist)
+	}
+}
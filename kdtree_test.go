@@ -0,0 +1,58 @@
+package palettize
+
+import (
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// bruteNearestIndex finds the nearest color to c in colors by linear scan,
+// for comparison against KDPalette's O(log N) search.
+func bruteNearestIndex(colors []color.Color, point func(color.Color) [3]float64, c color.Color) int {
+	target := point(c)
+	best, bestDist := 0, math.Inf(1)
+	for i, candidate := range colors {
+		if d := sqDist3(target, point(candidate)); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func TestKDPaletteNearestIndexMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	colors := make([]color.Color, 200)
+	for i := range colors {
+		colors[i] = color.RGBA64{
+			R: uint16(rng.Intn(65536)),
+			G: uint16(rng.Intn(65536)),
+			B: uint16(rng.Intn(65536)),
+			A: 0xffff,
+		}
+	}
+
+	for _, point := range []func(color.Color) [3]float64{rgbPoint, SpaceLab.point, SpaceOklab.point} {
+		tree := NewKDPalette(colors, point)
+		for i := 0; i < 200; i++ {
+			query := color.RGBA64{
+				R: uint16(rng.Intn(65536)),
+				G: uint16(rng.Intn(65536)),
+				B: uint16(rng.Intn(65536)),
+				A: 0xffff,
+			}
+			got := tree.NearestIndex(query)
+			want := bruteNearestIndex(colors, point, query)
+			if got != want {
+				t.Fatalf("NearestIndex(%v) = %d, want %d (brute force)", query, got, want)
+			}
+		}
+	}
+}
+
+func TestKDPaletteNearestIndexEmpty(t *testing.T) {
+	tree := NewKDPalette(nil, rgbPoint)
+	if got := tree.NearestIndex(color.White); got != -1 {
+		t.Errorf("NearestIndex on empty palette = %d, want -1", got)
+	}
+}
@@ -1,22 +1,18 @@
 /*
-Palettize creates a composite image using the brightness of one image and the
-color palette of another. Supports GIF, JPEG, and PNG files.
+Package palettize maps the brightness of one image onto the color palette
+of another, producing a composite image in the target's colors.
 
-Example syntax:
-    ./palettize original.png palette.png result.png
-
-The alogorithm used gets a list of the colors from each input file and sorts
-them by brightness. The color of each pixel in the first image is mapped onto
-the color at the corresponding point in the second image's color list in order
-to produce the result image.
+Colors from the source and target images are each sorted by brightness into
+a palette; the color of every source pixel is mapped onto the color at the
+corresponding point in the target's palette.
 */
-package main
+package palettize
 
 import (
 	"errors"
-	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
@@ -26,35 +22,55 @@ import (
 	"strings"
 )
 
-// Prints an error message to stderr and exits with a non-zero status.
-func die(err error) {
-	fmt.Fprintf(os.Stderr, err.Error()+"\n")
-	os.Exit(1)
-}
-
-// Gets an image from a GIF, JPEG, or PNG file.
-func readImage(filename string) image.Image {
+// ReadImage decodes an image from a GIF, JPEG, or PNG file.
+func ReadImage(filename string) (image.Image, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		die(err)
+		return nil, err
 	}
 	defer file.Close()
 
 	// Attempt to decode the file in different formats
-	image, err := png.Decode(file)
+	img, err := png.Decode(file)
 	if err != nil {
-        file.Seek(0, 0)
-		image, err = gif.Decode(file)
+		file.Seek(0, 0)
+		img, err = gif.Decode(file)
 		if err != nil {
-            file.Seek(0, 0)
-			image, err = jpeg.Decode(file)
-            if err != nil {
-                die(errors.New("unsupported file type: " + filename))
-            }
+			file.Seek(0, 0)
+			img, err = jpeg.Decode(file)
+			if err != nil {
+				return nil, errors.New("unsupported file type: " + filename)
+			}
 		}
 	}
 
-	return image
+	return img, nil
+}
+
+// WriteImage writes an image to a GIF, JPEG, or PNG file, chosen by
+// filename's extension.
+func WriteImage(img image.Image, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch {
+	case extMatch(filename, ".gif"):
+		return gif.Encode(file, img, &gif.Options{NumColors: 256})
+	case extMatch(filename, ".jpg") || extMatch(filename, ".jpeg"):
+		return jpeg.Encode(file, img, &jpeg.Options{Quality: 100})
+	case extMatch(filename, ".png"):
+		return png.Encode(file, img)
+	default:
+		return errors.New("unknown file extension: " + filepath.Ext(filename))
+	}
+}
+
+// Returns true if filename has extension ext, false otherwise.
+func extMatch(filename, ext string) bool {
+	return strings.ToLower(filepath.Ext(filename)) == strings.ToLower(ext)
 }
 
 // Returns true if the color is transparent, false if it is opaque.
@@ -63,34 +79,28 @@ func transparent(c color.Color) bool {
 	return a == 0
 }
 
-// ByBrightness implements sort.Interface for []color.Color based on value
-// (brightness).
-type ByBrightness []color.Color
-
-func (a ByBrightness) Len() int      { return len(a) }
-func (a ByBrightness) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a ByBrightness) Less(i, j int) bool {
-	ri, gi, bi, _ := a[i].RGBA()
-	rj, gj, bj, _ := a[j].RGBA()
-	return (ri + gi + bi) < (rj + gj + bj)
-}
-
-// Gets a slice of colors from an image, sorted from least to most brightness.
-func getPalette(img image.Image) []color.Color {
-
-	// Get colors from image
-	allColors := make([]color.Color, 0)
+// Gets every opaque pixel color in an image, in scanline order, including
+// duplicates.
+func opaquePixels(img image.Image) []color.Color {
+	pixels := make([]color.Color, 0)
 	b := img.Bounds()
 	for x := b.Min.X; x < b.Max.X; x++ {
 		for y := b.Min.Y; y < b.Max.Y; y++ {
 			if !transparent(img.At(x, y)) {
-				allColors = append(allColors, img.At(x, y))
+				pixels = append(pixels, img.At(x, y))
 			}
 		}
 	}
+	return pixels
+}
+
+// Gets a slice of colors from an image, sorted from least to most brightness
+// according to brightness.
+func getPalette(img image.Image, brightness BrightnessFunc) []color.Color {
+	allColors := opaquePixels(img)
 
 	// Convert slice of colors into sorted set of (unique) colors
-	sort.Sort(ByBrightness(allColors))
+	sort.Sort(byBrightness{allColors, brightness})
 	palette := make([]color.Color, 0)
 	for _, c := range allColors {
 		if len(palette) == 0 || palette[len(palette)-1] != c {
@@ -101,81 +111,256 @@ func getPalette(img image.Image) []color.Color {
 	return palette
 }
 
-// Gets the index of a color in a slice of colors, or -1 if not found.
-func indexOf(c color.Color, colors []color.Color) int {
-	for i := 0; i < len(colors); i++ {
-		if colors[i] == c {
-			return i
-		}
+// resolveBrightness picks the BrightnessFunc a palette operation should use:
+// space's lightness component if space isn't SpaceRGB, else brightness if
+// set, else sum.
+func resolveBrightness(brightness BrightnessFunc, space ColorSpace) BrightnessFunc {
+	if b := space.brightness(); b != nil {
+		return b
+	}
+	if brightness != nil {
+		return brightness
 	}
+	return sumBrightness
+}
+
+// PaletteOptions configures ExtractPalette. The zero value sorts by summed
+// RGB brightness and returns every unique opaque color.
+type PaletteOptions struct {
+	// Colors quantizes the result to this many representative colors via
+	// k-means. Zero disables quantization.
+	Colors int
+
+	// Brightness is the metric colors are sorted by. Nil defaults to
+	// summed RGB brightness, the historical behavior.
+	Brightness BrightnessFunc
 
-	return -1
+	// Space, if not SpaceRGB, sorts by that space's lightness instead of
+	// Brightness, and clusters quantized palettes (Colors > 0) by that
+	// space's distance instead of linear RGB.
+	Space ColorSpace
 }
 
-// Returns true if filename has extension ext, false otherwise.
-func extMatch(filename, ext string) bool {
-	return strings.ToLower(filepath.Ext(filename)) == strings.ToLower(ext)
+// ExtractPalette returns the colors of img as a color.Palette, sorted from
+// least to most bright.
+func ExtractPalette(img image.Image, opts *PaletteOptions) color.Palette {
+	var o PaletteOptions
+	if opts != nil {
+		o = *opts
+	}
+	brightness := resolveBrightness(o.Brightness, o.Space)
+
+	if o.Colors > 0 {
+		return color.Palette(quantizePalette(img, o.Colors, brightness, o.Space).colors)
+	}
+	return color.Palette(getPalette(img, brightness))
 }
 
-// Writes an image to a GIF, JPEG, or PNG file.
-func writeImage(img image.Image, filename string) {
-	file, err := os.Create(filename)
-	if err != nil {
-		die(err)
+// Options configures Palettize and PalettizeGIF. The zero value sorts by
+// summed RGB brightness, uses every unique opaque color, and applies no
+// dithering.
+type Options struct {
+	// Colors quantizes each palette to this many representative colors via
+	// k-means. Zero disables quantization.
+	Colors int
+
+	// Brightness is the metric colors are sorted and compared by. Nil
+	// defaults to summed RGB brightness, the historical behavior.
+	Brightness BrightnessFunc
+
+	// Space, if not SpaceRGB, sorts and compares by that space's lightness
+	// and distance instead of Brightness.
+	Space ColorSpace
+
+	// Dither selects the dithering algorithm applied before palette
+	// lookup. The zero value, DitherNone, applies none.
+	Dither DitherMode
+
+	// DitherSpread controls the strength of Bayer dithering. Zero picks
+	// 1/len(target palette).
+	DitherSpread float64
+}
+
+// Palettize maps every pixel of src onto target's color palette,
+// brightness-sorted index by brightness-sorted index, and writes the
+// result into dst. dst and src must share the same bounds. Transparent
+// source pixels are copied through unchanged rather than mapped onto
+// target's palette.
+func Palettize(dst draw.Image, src, target image.Image, opts *Options) error {
+	var o Options
+	if opts != nil {
+		o = *opts
 	}
-	defer file.Close()
+	brightness := resolveBrightness(o.Brightness, o.Space)
+
+	oldPalette, oldIndex := sourcePalette(src, &o, brightness)
+	newPalette := ExtractPalette(target, &PaletteOptions{Colors: o.Colors, Brightness: brightness, Space: o.Space})
+	if len(oldPalette) == 0 || len(newPalette) == 0 {
+		return errors.New("palettize: source and target must each contain at least one opaque color")
+	}
+	ratio := float64(len(newPalette)) / float64(len(oldPalette))
+	ditherSpread := ditherSpread(o.DitherSpread, len(newPalette))
 
-	// Write file based on given extension
-	if extMatch(filename, ".gif") {
-		gif.Encode(file, img, &gif.Options{256, nil, nil})
-	} else if extMatch(filename, ".jpg") || extMatch(filename, ".jpeg") {
-		jpeg.Encode(file, img, &jpeg.Options{100})
-	} else if extMatch(filename, ".png") {
-		png.Encode(file, img)
-	} else {
-		die(errors.New("unknown file extension: " + filepath.Ext(filename)))
+	b := src.Bounds()
+	var fs *floydSteinberg
+	if o.Dither == DitherFloydSteinberg {
+		fs = newFloydSteinberg(b)
 	}
+
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			srcPixel := src.At(x, y)
+			if transparent(srcPixel) {
+				dst.Set(x, y, srcPixel)
+				continue
+			}
+			pixel := ditherPixel(srcPixel, x, y, o.Dither, ditherSpread, fs)
+			index := oldIndex(pixel)
+			chosen := newPalette[int(float64(index)*ratio)]
+			if fs != nil {
+				fs.Propagate(pixel, chosen, x, y)
+			}
+			dst.Set(x, y, chosen)
+		}
+	}
+
+	return nil
 }
 
-func main() {
-	if len(os.Args) != 4 {
-		die(errors.New(fmt.Sprintf("Usage: %s original palette result",
-			os.Args[0])))
+// gifMaxColors is the largest palette a GIF frame can hold.
+const gifMaxColors = 256
+
+// PalettizeGIF palettizes every frame of g against target's color palette,
+// in place. All frames share one source palette and k-d tree, extracted
+// from the first frame, since animated GIF frames conventionally share a
+// single global color table. Unlike WriteImage's still-image GIF path,
+// which lets gif.Encode self-quantize an oversized palette, PalettizeGIF
+// builds image.Paletted frames directly, so it quantizes target's palette
+// to gifMaxColors itself if it would otherwise be too large to encode.
+// Transparent source pixels are left transparent in the output rather than
+// mapped onto target's palette.
+func PalettizeGIF(g *gif.GIF, target image.Image, opts *Options) error {
+	if len(g.Image) == 0 {
+		return nil
 	}
 
-	valueImg := readImage(os.Args[1])
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	brightness := resolveBrightness(o.Brightness, o.Space)
 
-	oldPalette := getPalette(valueImg)
-	newPalette := getPalette(readImage(os.Args[2]))
+	oldPalette, oldIndex := sourcePalette(g.Image[0], &o, brightness)
 
+	// Reserve a palette slot for transparency if any frame uses it, since
+	// GIF frames can't hold more than gifMaxColors entries total.
+	maxColors := gifMaxColors
+	if framesHaveTransparency(g.Image) {
+		maxColors--
+	}
+	newPalette := ExtractPalette(target, &PaletteOptions{Colors: o.Colors, Brightness: brightness, Space: o.Space})
+	if len(newPalette) > maxColors {
+		// The single-frame path gets this for free from gif.Encode's
+		// NumColors option; here we have to quantize ourselves.
+		newPalette = ExtractPalette(target, &PaletteOptions{Colors: maxColors, Brightness: brightness, Space: o.Space})
+	}
+	if len(oldPalette) == 0 || len(newPalette) == 0 {
+		return errors.New("palettize: source and target must each contain at least one opaque color")
+	}
 	ratio := float64(len(newPalette)) / float64(len(oldPalette))
+	ditherSpread := ditherSpread(o.DitherSpread, len(newPalette))
 
-	b := valueImg.Bounds()
-	imgOut := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	width := b.Max.X - b.Min.X
-	for x := b.Min.X; x < b.Max.X; x++ {
+	transparentIndex := -1
+	if maxColors < gifMaxColors {
+		transparentIndex = len(newPalette)
+		newPalette = append(newPalette, color.RGBA{})
+	}
 
-		// Progress display
-		colNumber := x - b.Min.X + 1
-		fmt.Printf("\rConverting column %d of %d (%d%%)", colNumber, width,
-			100*colNumber/width)
-		os.Stdout.Sync()
+	for _, frame := range g.Image {
+		b := frame.Bounds()
+		var fs *floydSteinberg
+		if o.Dither == DitherFloydSteinberg {
+			fs = newFloydSteinberg(b)
+		}
 
-		for y := b.Min.Y; y < b.Max.Y; y++ {
-			index := indexOf(valueImg.At(x, y), oldPalette)
-			if index != -1 {
-				imgOut.Set(x, y, newPalette[int(float64(index)*ratio)])
-			} else {
-				imgOut.Set(x, y, valueImg.At(x, y))
+		out := image.NewPaletted(b, newPalette)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				srcPixel := frame.At(x, y)
+				if transparent(srcPixel) {
+					out.SetColorIndex(x, y, uint8(transparentIndex))
+					continue
+				}
+				pixel := ditherPixel(srcPixel, x, y, o.Dither, ditherSpread, fs)
+				index := oldIndex(pixel)
+				chosen := newPalette[int(float64(index)*ratio)]
+				if fs != nil {
+					fs.Propagate(pixel, chosen, x, y)
+				}
+				out.Set(x, y, chosen)
 			}
 		}
+		*frame = *out
 	}
 
-	// Erase progress display
-	print("\r                                     \r")
-	os.Stdout.Sync()
+	return nil
+}
 
-	writeImage(imgOut, os.Args[3])
+// framesHaveTransparency reports whether any frame's palette contains a
+// transparent color, which marks some of its pixels as transparent.
+func framesHaveTransparency(frames []*image.Paletted) bool {
+	for _, frame := range frames {
+		for _, c := range frame.Palette {
+			if transparent(c) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-// vim: ts=4 sw=0
+// sourcePalette extracts img's palette along with a function mapping any
+// color to its nearest index in that palette: either a lookup into the
+// index k-means quantization already computed, or a k-d tree built over
+// the full palette.
+func sourcePalette(img image.Image, o *Options, brightness BrightnessFunc) (color.Palette, func(color.Color) int) {
+	if o.Colors > 0 {
+		quantized := quantizePalette(img, o.Colors, brightness, o.Space)
+		// quantized.index only covers colors seen in img. PalettizeGIF
+		// reuses this lookup across every frame, so fall back to a k-d
+		// tree over the quantized palette for colors it hasn't seen,
+		// rather than let a map miss silently resolve to index 0.
+		fallback := NewKDPalette(quantized.colors, o.Space.point).NearestIndex
+		index := func(c color.Color) int {
+			if i, ok := quantized.index[c]; ok {
+				return i
+			}
+			return fallback(c)
+		}
+		return color.Palette(quantized.colors), index
+	}
+	palette := getPalette(img, brightness)
+	return color.Palette(palette), NewKDPalette(palette, o.Space.point).NearestIndex
+}
+
+// ditherSpread resolves the configured spread, defaulting to 1/paletteSize
+// when unset.
+func ditherSpread(spread float64, paletteSize int) float64 {
+	if spread == 0 && paletteSize > 0 {
+		return 1 / float64(paletteSize)
+	}
+	return spread
+}
+
+// ditherPixel applies the configured dithering to a pixel before palette
+// lookup. fs is only consulted (and must be non-nil) for DitherFloydSteinberg.
+func ditherPixel(c color.Color, x, y int, mode DitherMode, spread float64, fs *floydSteinberg) color.Color {
+	switch mode {
+	case DitherFloydSteinberg:
+		return fs.Apply(c, x, y)
+	case DitherBayer4, DitherBayer8:
+		return orderedDither(c, x, y, mode, spread)
+	default:
+		return c
+	}
+}
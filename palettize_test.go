@@ -0,0 +1,105 @@
+package palettize
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestPalettizeMapsBrightnessOntoTargetPalette(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{A: 255})                         // darkest
+	src.Set(1, 0, color.RGBA{R: 255, G: 255, B: 255, A: 255}) // brightest
+
+	target := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	target.Set(0, 0, color.RGBA{B: 255, A: 255})         // darkest by sum
+	target.Set(1, 0, color.RGBA{R: 255, A: 255, G: 255}) // brightest by sum
+
+	dst := image.NewRGBA(src.Bounds())
+	if err := Palettize(dst, src, target, &Options{}); err != nil {
+		t.Fatalf("Palettize returned error: %v", err)
+	}
+
+	if got, want := dst.At(0, 0), target.At(0, 0); got != want {
+		t.Errorf("dst(0,0) = %v, want the darkest target color %v", got, want)
+	}
+	if got, want := dst.At(1, 0), target.At(1, 0); got != want {
+		t.Errorf("dst(1,0) = %v, want the brightest target color %v", got, want)
+	}
+}
+
+// TestPalettizePreservesTransparency locks in the fix where transparent
+// source pixels stopped passing through unchanged once nearest-color
+// lookup via the k-d tree replaced the old indexOf-based pass-through:
+// NearestIndex always finds a match, so a transparent pixel must be
+// special-cased before it ever reaches that lookup.
+func TestPalettizePreservesTransparency(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{}) // fully transparent
+	src.Set(1, 0, color.RGBA{R: 255, A: 255})
+
+	target := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	target.Set(0, 0, color.RGBA{B: 255, A: 255})
+	target.Set(1, 0, color.RGBA{G: 255, A: 255})
+
+	dst := image.NewRGBA(src.Bounds())
+	if err := Palettize(dst, src, target, &Options{}); err != nil {
+		t.Fatalf("Palettize returned error: %v", err)
+	}
+
+	if _, _, _, a := dst.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("dst(0,0) alpha = %d, want 0 (transparent pixel should pass through unchanged)", a)
+	}
+	if _, _, _, a := dst.At(1, 0).RGBA(); a == 0 {
+		t.Errorf("dst(1,0) = %v, want an opaque target color", dst.At(1, 0))
+	}
+}
+
+func TestPalettizeRejectsAllTransparentInput(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	target := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	target.Set(0, 0, color.RGBA{R: 255, A: 255})
+
+	dst := image.NewRGBA(src.Bounds())
+	if err := Palettize(dst, src, target, &Options{}); err == nil {
+		t.Error("Palettize with a fully transparent source returned nil error, want one")
+	}
+}
+
+// TestPalettizeGIFPreservesTransparency is the animated-GIF analog of
+// TestPalettizePreservesTransparency: a transparent palette index must
+// survive into the output palette and stay assigned to transparent pixels.
+func TestPalettizeGIFPreservesTransparency(t *testing.T) {
+	b := image.Rect(0, 0, 2, 1)
+	pal := color.Palette{color.RGBA{R: 255, A: 255}, color.RGBA{}}
+	frame := image.NewPaletted(b, pal)
+	frame.SetColorIndex(0, 0, 0) // opaque red
+	frame.SetColorIndex(1, 0, 1) // transparent
+
+	g := &gif.GIF{Image: []*image.Paletted{frame}, Delay: []int{0}}
+
+	target := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	target.Set(0, 0, color.RGBA{G: 255, A: 255})
+
+	if err := PalettizeGIF(g, target, &Options{}); err != nil {
+		t.Fatalf("PalettizeGIF returned error: %v", err)
+	}
+
+	if _, _, _, a := g.Image[0].At(0, 0).RGBA(); a == 0 {
+		t.Errorf("frame(0,0) = %v, want an opaque color", g.Image[0].At(0, 0))
+	}
+	if _, _, _, a := g.Image[0].At(1, 0).RGBA(); a != 0 {
+		t.Errorf("frame(1,0) alpha = %d, want 0 (transparent pixel should stay transparent)", a)
+	}
+
+	hasTransparentEntry := false
+	for _, c := range g.Image[0].Palette {
+		if transparent(c) {
+			hasTransparentEntry = true
+		}
+	}
+	if !hasTransparentEntry {
+		t.Error("output palette has no transparent entry")
+	}
+}
@@ -0,0 +1,254 @@
+package palettize
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// quantizeMaxSamples bounds how many pixels k-means actually clusters
+	// over; the full pixel set of a photo is unnecessary for fitting good
+	// centroids.
+	quantizeMaxSamples  = 20000
+	quantizeMaxRounds   = 20
+	quantizeMinMovement = 1e-4
+)
+
+// quantizeSpace maps colors to and from the 3-D point quantizePalette
+// clusters in, so that k-means distance agrees with whatever ColorSpace the
+// caller is comparing colors in elsewhere. RGB clusters in linear RGB, since
+// perceptual and arithmetic distance in sRGB don't agree; Lab and Oklab
+// cluster in their own coordinates.
+type quantizeSpace struct {
+	to   func(color.Color) [3]float64
+	from func([3]float64) color.Color
+}
+
+func spaceFor(space ColorSpace) quantizeSpace {
+	switch space {
+	case SpaceLab:
+		return quantizeSpace{
+			to:   space.point,
+			from: func(p [3]float64) color.Color { return labToColor(p[0], p[1], p[2]) },
+		}
+	case SpaceOklab:
+		return quantizeSpace{
+			to:   space.point,
+			from: func(p [3]float64) color.Color { return oklabToColor(p[0], p[1], p[2]) },
+		}
+	default:
+		return quantizeSpace{to: toLinearRGB, from: linearRGBToColor}
+	}
+}
+
+func toLinearRGB(c color.Color) [3]float64 {
+	r, g, b, _ := c.RGBA()
+	return [3]float64{linearize(r), linearize(g), linearize(b)}
+}
+
+// linearRGBToColor converts a linear RGB point back to an opaque sRGB color.
+func linearRGBToColor(v [3]float64) color.Color {
+	return color.RGBA64{
+		R: delinearize(v[0]),
+		G: delinearize(v[1]),
+		B: delinearize(v[2]),
+		A: 0xffff,
+	}
+}
+
+// delinearize is the inverse of linearize, converting linear light back to
+// a 16-bit sRGB channel value.
+func delinearize(cl float64) uint16 {
+	var cs float64
+	if cl <= 0.0031308 {
+		cs = cl * 12.92
+	} else {
+		cs = 1.055*math.Pow(cl, 1/2.4) - 0.055
+	}
+	switch {
+	case cs <= 0:
+		return 0
+	case cs >= 1:
+		return 0xffff
+	default:
+		return uint16(cs*65535 + 0.5)
+	}
+}
+
+// quantizedPalette is the result of quantizePalette: a reduced palette plus
+// a lookup from every distinct color in the source image to its index in
+// that palette.
+type quantizedPalette struct {
+	colors []color.Color
+	index  map[color.Color]int
+}
+
+// quantizePalette runs k-means over img's opaque pixels to produce k
+// representative colors, sorted from least to most bright according to
+// brightness, along with an index assignment for every distinct color in
+// img. Clustering distance is computed in space's coordinates (see
+// quantizeSpace), so that --colors and --space agree on what "nearest"
+// means.
+func quantizePalette(img image.Image, k int, brightness BrightnessFunc, space ColorSpace) quantizedPalette {
+	pixels := opaquePixels(img)
+	if len(pixels) == 0 {
+		return quantizedPalette{}
+	}
+	if k > len(pixels) {
+		k = len(pixels)
+	}
+
+	sp := spaceFor(space)
+	sample := samplePixels(pixels, quantizeMaxSamples)
+	vecs := make([][3]float64, len(sample))
+	for i, c := range sample {
+		vecs[i] = sp.to(c)
+	}
+
+	centroids := kmeansPlusPlusInit(vecs, k)
+	assignments := make([]int, len(vecs))
+	for round := 0; round < quantizeMaxRounds; round++ {
+		for i, v := range vecs {
+			assignments[i] = nearestCentroid(v, centroids)
+		}
+		updated, movement := updateCentroids(vecs, assignments, centroids)
+		centroids = updated
+		if movement < quantizeMinMovement {
+			break
+		}
+	}
+
+	// Sort the centroids by brightness, keeping track of how each original
+	// centroid index maps to its sorted position.
+	colors := make([]color.Color, len(centroids))
+	for i, c := range centroids {
+		colors[i] = sp.from(c)
+	}
+	order := make([]int, len(centroids))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return brightness(colors[order[i]]) < brightness(colors[order[j]])
+	})
+	rank := make([]int, len(centroids))
+	sortedColors := make([]color.Color, len(centroids))
+	for newIndex, oldIndex := range order {
+		rank[oldIndex] = newIndex
+		sortedColors[newIndex] = colors[oldIndex]
+	}
+
+	index := make(map[color.Color]int, len(pixels))
+	for _, c := range pixels {
+		if _, ok := index[c]; ok {
+			continue
+		}
+		index[c] = rank[nearestCentroid(sp.to(c), centroids)]
+	}
+
+	return quantizedPalette{colors: sortedColors, index: index}
+}
+
+// samplePixels returns up to max pixels drawn at random from pixels.
+func samplePixels(pixels []color.Color, max int) []color.Color {
+	if len(pixels) <= max {
+		return pixels
+	}
+	sample := make([]color.Color, max)
+	for i := range sample {
+		sample[i] = pixels[rand.Intn(len(pixels))]
+	}
+	return sample
+}
+
+// kmeansPlusPlusInit picks k initial centroids from vecs using k-means++:
+// the first centroid is chosen uniformly at random, and each subsequent one
+// with probability proportional to its squared distance from the nearest
+// centroid chosen so far.
+func kmeansPlusPlusInit(vecs [][3]float64, k int) [][3]float64 {
+	centroids := make([][3]float64, 0, k)
+	centroids = append(centroids, vecs[rand.Intn(len(vecs))])
+
+	sqDists := make([]float64, len(vecs))
+	for len(centroids) < k {
+		var total float64
+		for i, v := range vecs {
+			d := nearestSqDist(v, centroids)
+			sqDists[i] = d
+			total += d
+		}
+		if total == 0 {
+			centroids = append(centroids, vecs[rand.Intn(len(vecs))])
+			continue
+		}
+		target := rand.Float64() * total
+		var cum float64
+		for i, d := range sqDists {
+			cum += d
+			if cum >= target {
+				centroids = append(centroids, vecs[i])
+				break
+			}
+		}
+	}
+
+	return centroids
+}
+
+func nearestSqDist(v [3]float64, centroids [][3]float64) float64 {
+	best := math.Inf(1)
+	for _, c := range centroids {
+		if d := sqDist3(v, c); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// nearestCentroid returns the index of the centroid closest to v.
+func nearestCentroid(v [3]float64, centroids [][3]float64) int {
+	best, bestDist := 0, math.Inf(1)
+	for i, c := range centroids {
+		if d := sqDist3(v, c); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// updateCentroids recomputes each centroid as the mean of the vectors
+// assigned to it, returning the new centroids and the largest distance any
+// centroid moved. Centroids with no assigned vectors are left in place.
+func updateCentroids(vecs [][3]float64, assignments []int, old [][3]float64) ([][3]float64, float64) {
+	sums := make([][3]float64, len(old))
+	counts := make([]int, len(old))
+	for i, v := range vecs {
+		a := assignments[i]
+		sums[a][0] += v[0]
+		sums[a][1] += v[1]
+		sums[a][2] += v[2]
+		counts[a]++
+	}
+
+	updated := make([][3]float64, len(old))
+	var maxMovement float64
+	for i := range old {
+		if counts[i] == 0 {
+			updated[i] = old[i]
+			continue
+		}
+		updated[i] = [3]float64{
+			sums[i][0] / float64(counts[i]),
+			sums[i][1] / float64(counts[i]),
+			sums[i][2] / float64(counts[i]),
+		}
+		if d := math.Sqrt(sqDist3(updated[i], old[i])); d > maxMovement {
+			maxMovement = d
+		}
+	}
+
+	return updated, maxMovement
+}
@@ -0,0 +1,60 @@
+package palettize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// labDistinguishableImage returns a synthetic image with two clusters of
+// colors that are close in RGB but far apart in Lab lightness, so that
+// clustering in Lab space (rather than linear RGB) changes which centroids
+// k-means finds.
+func labDistinguishableImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	// A dark, saturated blue and a light, desaturated blue: close together
+	// in raw sRGB terms, but far apart in Lab lightness.
+	dark := color.RGBA{R: 10, G: 10, B: 60, A: 255}
+	light := color.RGBA{R: 200, G: 200, B: 220, A: 255}
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			if x < 2 {
+				img.Set(x, y, dark)
+			} else {
+				img.Set(x, y, light)
+			}
+		}
+	}
+	return img
+}
+
+func TestQuantizePaletteRespectsSpace(t *testing.T) {
+	img := labDistinguishableImage()
+
+	rgb := quantizePalette(img, 2, sumBrightness, SpaceRGB)
+	lab := quantizePalette(img, 2, func(c color.Color) float64 { l, _, _ := toLab(c); return l }, SpaceLab)
+
+	if len(rgb.colors) != 2 || len(lab.colors) != 2 {
+		t.Fatalf("got %d RGB colors and %d Lab colors, want 2 each", len(rgb.colors), len(lab.colors))
+	}
+
+	same := true
+	for i := range rgb.colors {
+		rr, rg, rb, _ := rgb.colors[i].RGBA()
+		lr, lg, lb, _ := lab.colors[i].RGBA()
+		if rr != lr || rg != lg || rb != lb {
+			same = false
+		}
+	}
+	if same {
+		t.Error("quantizePalette produced identical centroids for SpaceRGB and SpaceLab; Space isn't affecting clustering")
+	}
+}
+
+func TestQuantizePaletteEmptyImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	q := quantizePalette(img, 2, sumBrightness, SpaceRGB)
+	if len(q.colors) != 0 {
+		t.Errorf("quantizePalette on a fully transparent image returned %d colors, want 0", len(q.colors))
+	}
+}